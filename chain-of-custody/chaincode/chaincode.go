@@ -1,40 +1,126 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
 )
 
+// auditorMSPID is the org that is always granted endorsement rights on
+// evidence records alongside the current owner's org, so an independent
+// auditor can always verify a custody change without being able to
+// unilaterally approve one on its own.
+const auditorMSPID = "AuditorMSP"
+
+const policyKey = "POLICY"
+
+// destroyedStatus is the terminal Status a soft-deleted evidence record is
+// set to by DeleteEvidence. It may only be entered or left through
+// DeleteEvidence, which is admin-gated and writes the tombstone transfer and
+// EvidenceDeleted event the audit trail depends on.
+const destroyedStatus = "Destroyed"
+
+// partyCertIndex namespaces the enrolled X.509 certificate registered for
+// each custody party (an Evidence.Owner value) under a composite key, so
+// TransferCustodyWithSignatures can verify a hand-off signature against the
+// actual from-owner/to-owner certificate instead of the submitter's own.
+const partyCertIndex = "PARTY_CERT~owner"
+
 type ChainOfCustodyContract struct {
 	contractapi.Contract
 }
 
 type Evidence struct {
-	ID          string   `json:"id"`	
-	Description string   `json:"description"`
-	Owner       string   `json:"owner"`
-	Location    string   `json:"location"`
-	Status      string   `json:"status"`	
-	CreatedAt   string   `json:"created_at"`
-	UpdatedAt   string   `json:"updated_at"`
-	Tags        []string `json:"tags"`
+	ID           string   `json:"id"`
+	Description  string   `json:"description"`
+	Owner        string   `json:"owner"`
+	Location     string   `json:"location"`
+	Status       string   `json:"status"`
+	CreatedAt    string   `json:"created_at"`
+	UpdatedAt    string   `json:"updated_at"`
+	Tags         []string `json:"tags"`
+	ParentIDs    []string `json:"parent_ids"`
+	DerivedIDs   []string `json:"derived_ids"`
+	Certificates []string `json:"certificates"`
+	ClaimTags    []string `json:"claim_tags"`
+	ContentHash  string   `json:"content_hash"`
+	HashAlgo     string   `json:"hash_algo"`
+	OwnerMSPID   string   `json:"owner_msp_id"`
 }
 
+// OrgPolicy maps an MSP ID to the roles it is permitted to act under. It is
+// persisted as a single JSON object under policyKey.
+type OrgPolicy map[string][]string
+
 type CustodyTransfer struct {
-	EvidenceID   string `json:"evidence_id"`
-	FromOwner    string `json:"from_owner"`
-	ToOwner      string `json:"to_owner"`
-	Timestamp    string `json:"timestamp"`
-	Reason       string `json:"reason"`
-	TransferredBy string `json:"transferred_by"`
+	EvidenceID       string `json:"evidence_id"`
+	FromOwner        string `json:"from_owner"`
+	ToOwner          string `json:"to_owner"`
+	Timestamp        string `json:"timestamp"`
+	Reason           string `json:"reason"`
+	TransferredBy    string `json:"transferred_by"`
+	FromSignature    string `json:"from_signature,omitempty"`
+	ToSignature      string `json:"to_signature,omitempty"`
+	PriorContentHash string `json:"prior_content_hash,omitempty"`
+}
+
+// DerivationLink records that childID was derived from parentID, e.g. a DNA
+// swab extracted from a weapon, or a lab sample split from a larger batch.
+type DerivationLink struct {
+	ParentID  string `json:"parent_id"`
+	ChildID   string `json:"child_id"`
+	Reason    string `json:"reason"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Certificate is a lab report, chain-of-custody document, or other
+// attestation attached to a piece of evidence.
+type Certificate struct {
+	EvidenceID string `json:"evidence_id"`
+	CertID     string `json:"cert_id"`
+	CertHash   string `json:"cert_hash"`
+	Issuer     string `json:"issuer"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// EvidenceLineage is the DAG of evidence reachable from a root ID by
+// following ParentIDs and DerivedIDs in both directions, together with the
+// custody transfers and certificates recorded against every node visited.
+type EvidenceLineage struct {
+	RootID       string               `json:"root_id"`
+	Evidence     map[string]*Evidence `json:"evidence"`
+	Links        []DerivationLink     `json:"links"`
+	Transfers    []CustodyTransfer    `json:"transfers"`
+	Certificates []Certificate        `json:"certificates"`
 }
 
 
 func (c *ChainOfCustodyContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
+	now, err := c.txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	ownerMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to resolve client MSP: %v", err)
+	}
+
 	evidences := []Evidence{
 		{
 			ID:          "EV001",
@@ -42,9 +128,10 @@ func (c *ChainOfCustodyContract) InitLedger(ctx contractapi.TransactionContextIn
 			Owner:       "Officer Smith",
 			Location:    "Evidence Locker A1",
 			Status:      "Collected",
-			CreatedAt:   time.Now().Format(time.RFC3339),
-			UpdatedAt:   time.Now().Format(time.RFC3339),
+			CreatedAt:   now,
+			UpdatedAt:   now,
 			Tags:        []string{"sample", "test"},
+			OwnerMSPID:  ownerMSPID,
 		},
 	}
 
@@ -58,6 +145,14 @@ func (c *ChainOfCustodyContract) InitLedger(ctx contractapi.TransactionContextIn
 		if err != nil {
 			return fmt.Errorf("failed to put evidence to world state: %v", err)
 		}
+
+		if err := c.setEvidenceEndorsementPolicy(ctx, evidence.ID, ownerMSPID); err != nil {
+			return err
+		}
+
+		if err := c.addEvidenceIndexes(ctx, &evidence); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -80,19 +175,34 @@ func (c *ChainOfCustodyContract) validateNewEvidenceID(ctx contractapi.Transacti
 }
 
 func (c *ChainOfCustodyContract) CreateEvidence(ctx contractapi.TransactionContextInterface, id string, description string, owner string, location string, tags []string) error {
+    if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
+        return err
+    }
+
     if err := c.validateNewEvidenceID(ctx, id); err != nil {
         return err
     }
 
+    ownerMSPID, err := ctx.GetClientIdentity().GetMSPID()
+    if err != nil {
+        return fmt.Errorf("failed to resolve client MSP: %v", err)
+    }
+
+    now, err := c.txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
     evidence := Evidence{
         ID:          id,
         Description: description,
         Owner:       owner,
         Location:    location,
         Status:      "Collected",
-        CreatedAt:   time.Now().Format(time.RFC3339),
-        UpdatedAt:   time.Now().Format(time.RFC3339),
+        CreatedAt:   now,
+        UpdatedAt:   now,
         Tags:        tags,
+        OwnerMSPID:  ownerMSPID,
     }
 
     evidenceJSON, err := json.Marshal(evidence)
@@ -105,7 +215,21 @@ func (c *ChainOfCustodyContract) CreateEvidence(ctx contractapi.TransactionConte
         return err
     }
 
-    return c.addToEvidenceIndex(ctx, id)
+    if err := c.setEvidenceEndorsementPolicy(ctx, id, ownerMSPID); err != nil {
+        return err
+    }
+
+    if err := c.addEvidenceIndexes(ctx, &evidence); err != nil {
+        return err
+    }
+
+    return c.emitEvidenceEvent(ctx, "EvidenceCreated", id, map[string]interface{}{
+        "description": description,
+        "owner":       owner,
+        "location":    location,
+        "status":      evidence.Status,
+        "tags":        tags,
+    })
 }
 
 func (c *ChainOfCustodyContract) ReadEvidence(ctx contractapi.TransactionContextInterface, id string) (*Evidence, error) {
@@ -127,37 +251,184 @@ func (c *ChainOfCustodyContract) ReadEvidence(ctx contractapi.TransactionContext
 }
 
 func (c *ChainOfCustodyContract) UpdateEvidence(ctx contractapi.TransactionContextInterface, id string, description string, location string, status string) error {
+	if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
+		return err
+	}
+
 	evidence, err := c.ReadEvidence(ctx, id)
 	if err != nil {
 		return err
 	}
 
+	if evidence.Status == destroyedStatus {
+		return fmt.Errorf("evidence %s has been destroyed and can no longer be updated", id)
+	}
+	if status == destroyedStatus {
+		return fmt.Errorf("status %q may only be set via DeleteEvidence", destroyedStatus)
+	}
+
+	if err := c.removeEvidenceIndexes(ctx, evidence); err != nil {
+		return err
+	}
+
+	changes := map[string]interface{}{}
+	if evidence.Description != description {
+		changes["description"] = map[string]string{"from": evidence.Description, "to": description}
+	}
+	if evidence.Location != location {
+		changes["location"] = map[string]string{"from": evidence.Location, "to": location}
+	}
+	if evidence.Status != status {
+		changes["status"] = map[string]string{"from": evidence.Status, "to": status}
+	}
+
 	evidence.Description = description
 	evidence.Location = location
 	evidence.Status = status
-	evidence.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	now, err := c.txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	evidence.UpdatedAt = now
 
 	evidenceJSON, err := json.Marshal(evidence)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(id, evidenceJSON)
+	if err := ctx.GetStub().PutState(id, evidenceJSON); err != nil {
+		return err
+	}
+
+	if err := c.addEvidenceIndexes(ctx, evidence); err != nil {
+		return err
+	}
+
+	return c.emitEvidenceEvent(ctx, "EvidenceUpdated", id, changes)
+}
+
+// SetClaimTags replaces the case-theory labels (e.g. "exhibit-A",
+// "chain-of-custody-disputed") recorded against a piece of evidence,
+// re-indexing claimTagIndex so GetEvidenceByClaimTag stays in sync.
+func (c *ChainOfCustodyContract) SetClaimTags(ctx contractapi.TransactionContextInterface, id string, claimTags []string) error {
+	if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
+		return err
+	}
+
+	evidence, err := c.ReadEvidence(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := c.removeEvidenceIndexes(ctx, evidence); err != nil {
+		return err
+	}
+
+	changes := map[string]interface{}{
+		"claim_tags": map[string]interface{}{"from": evidence.ClaimTags, "to": claimTags},
+	}
+
+	evidence.ClaimTags = claimTags
+
+	now, err := c.txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	evidence.UpdatedAt = now
+
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetStub().PutState(id, evidenceJSON); err != nil {
+		return err
+	}
+
+	if err := c.addEvidenceIndexes(ctx, evidence); err != nil {
+		return err
+	}
+
+	return c.emitEvidenceEvent(ctx, "EvidenceUpdated", id, changes)
 }
 
 func (c *ChainOfCustodyContract) TransferCustody(ctx contractapi.TransactionContextInterface, id string, newOwner string, reason string, transferredBy string) error {
+	if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
+		return err
+	}
+
+	evidence, err := c.ReadEvidence(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return c.recordCustodyTransfer(ctx, evidence, newOwner, reason, transferredBy, "", "")
+}
+
+// TransferCustodyWithSignatures is TransferCustody plus a cryptographic
+// hand-off proof: fromSig and toSig must be base64-encoded signatures over
+// the canonical transfer message, verifiable against the enrolled
+// certificates registered for evidence.Owner and newOwner respectively via
+// RegisterPartyCertificate. Verifying against the actual from/to parties'
+// certificates (rather than the submitter's own) closes the gap where
+// anyone who can invoke the chaincode could rewrite Owner without proof
+// that both parties agreed to the hand-off.
+func (c *ChainOfCustodyContract) TransferCustodyWithSignatures(ctx contractapi.TransactionContextInterface, id string, newOwner string, reason string, fromSig string, toSig string) error {
+	if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
+		return err
+	}
+
 	evidence, err := c.ReadEvidence(ctx, id)
 	if err != nil {
 		return err
 	}
 
+	message := []byte(fmt.Sprintf("%s|%s|%s|%s", id, evidence.Owner, newOwner, reason))
+
+	fromCert, err := c.getPartyCertificate(ctx, evidence.Owner)
+	if err != nil {
+		return fmt.Errorf("failed to resolve from-owner certificate: %v", err)
+	}
+	if err := c.verifySignature(fromCert, message, fromSig); err != nil {
+		return fmt.Errorf("from-signature invalid: %v", err)
+	}
+
+	toCert, err := c.getPartyCertificate(ctx, newOwner)
+	if err != nil {
+		return fmt.Errorf("failed to resolve to-owner certificate: %v", err)
+	}
+	if err := c.verifySignature(toCert, message, toSig); err != nil {
+		return fmt.Errorf("to-signature invalid: %v", err)
+	}
+
+	transferredBy, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to resolve invoking client identity: %v", err)
+	}
+
+	return c.recordCustodyTransfer(ctx, evidence, newOwner, reason, transferredBy, fromSig, toSig)
+}
+
+func (c *ChainOfCustodyContract) recordCustodyTransfer(ctx contractapi.TransactionContextInterface, evidence *Evidence, newOwner string, reason string, transferredBy string, fromSig string, toSig string) error {
+	now, err := c.txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	fromOwner := evidence.Owner
+	fromOwnerMSPID := evidence.OwnerMSPID
+
 	transfer := CustodyTransfer{
-		EvidenceID:   id,
-		FromOwner:    evidence.Owner,
-		ToOwner:      newOwner,
-		Timestamp:    time.Now().Format(time.RFC3339),
-		Reason:       reason,
-		TransferredBy: transferredBy,
+		EvidenceID:       evidence.ID,
+		FromOwner:        evidence.Owner,
+		ToOwner:          newOwner,
+		Timestamp:        now,
+		Reason:           reason,
+		TransferredBy:    transferredBy,
+		FromSignature:    fromSig,
+		ToSignature:      toSig,
+		PriorContentHash: evidence.ContentHash,
 	}
 
 	transferJSON, err := json.Marshal(transfer)
@@ -165,162 +436,1028 @@ func (c *ChainOfCustodyContract) TransferCustody(ctx contractapi.TransactionCont
 		return err
 	}
 
-	transferKey := fmt.Sprintf("TRANSFER_%s_%s", id, transfer.Timestamp)
-	err = ctx.GetStub().PutState(transferKey, transferJSON)
+	transferKey, err := ctx.GetStub().CreateCompositeKey(transferIndex, []string{evidence.ID, transfer.Timestamp})
 	if err != nil {
 		return err
 	}
+	if err := ctx.GetStub().PutState(transferKey, transferJSON); err != nil {
+		return err
+	}
+
+	if err := c.removeEvidenceIndexes(ctx, evidence); err != nil {
+		return err
+	}
+
+	ownerMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to resolve client MSP: %v", err)
+	}
 
 	evidence.Owner = newOwner
-	evidence.UpdatedAt = time.Now().Format(time.RFC3339)
+	evidence.OwnerMSPID = ownerMSPID
+	evidence.UpdatedAt = transfer.Timestamp
 
 	evidenceJSON, err := json.Marshal(evidence)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(id, evidenceJSON)
+	if err := ctx.GetStub().PutState(evidence.ID, evidenceJSON); err != nil {
+		return err
+	}
+
+	if err := c.setEvidenceEndorsementPolicy(ctx, evidence.ID, ownerMSPID); err != nil {
+		return err
+	}
+
+	if err := c.addEvidenceIndexes(ctx, evidence); err != nil {
+		return err
+	}
+
+	return c.emitEvidenceEvent(ctx, "CustodyTransferred", evidence.ID, map[string]interface{}{
+		"owner":         map[string]string{"from": fromOwner, "to": newOwner},
+		"owner_msp_id":  map[string]string{"from": fromOwnerMSPID, "to": ownerMSPID},
+		"reason":        reason,
+		"transferredBy": transferredBy,
+	})
 }
 
-func (c *ChainOfCustodyContract) GetEvidenceHistory(ctx contractapi.TransactionContextInterface, id string) ([]map[string]interface{}, error) {
-	resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
-	if err != nil {
-		return nil, err
+// RegisterPartyCertificate binds owner (an Evidence.Owner value) to its
+// enrolled X.509 certificate (PEM-encoded) so a later
+// TransferCustodyWithSignatures call can verify that party's hand-off
+// signature against its actual certificate instead of the submitter's.
+//
+// A caller may only register their own enrolled certificate (proven by
+// comparing certPEM against ctx.GetClientIdentity().GetX509Certificate()):
+// otherwise any officer/custodian could bind a certificate they hold the
+// private key for to a victim's owner name and later forge both sides of a
+// TransferCustodyWithSignatures hand-off. Registering a certificate other
+// than the caller's own requires the admin role.
+func (c *ChainOfCustodyContract) RegisterPartyCertificate(ctx contractapi.TransactionContextInterface, owner string, certPEM string) error {
+	if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
+		return err
 	}
-	defer resultsIterator.Close()
 
-	var history []map[string]interface{}
+	if strings.TrimSpace(owner) == "" {
+		return fmt.Errorf("owner must not be empty")
+	}
 
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
+	candidateCert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return fmt.Errorf("invalid certificate for %s: %v", owner, err)
+	}
 
-		var evidence Evidence
-		if len(response.Value) > 0 {
-			err = json.Unmarshal(response.Value, &evidence)
-			if err != nil {
-				return nil, err
-			}
-		}
+	callerCert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to load invoking client certificate: %v", err)
+	}
 
-		record := map[string]interface{}{
-			"txId":      response.TxId,
-			"timestamp": response.Timestamp,
-			"isDelete":  response.IsDelete,
-			"evidence":  evidence,
+	if !bytes.Equal(callerCert.Raw, candidateCert.Raw) {
+		if err := c.authorize(ctx, "admin"); err != nil {
+			return fmt.Errorf("only admin may register a certificate other than the caller's own: %v", err)
 		}
+	}
 
-		history = append(history, record)
+	key, err := ctx.GetStub().CreateCompositeKey(partyCertIndex, []string{owner})
+	if err != nil {
+		return err
 	}
 
-	return history, nil
+	return ctx.GetStub().PutState(key, []byte(certPEM))
 }
 
-func (c *ChainOfCustodyContract) GetAllEvidence(ctx contractapi.TransactionContextInterface) ([]*Evidence, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+// getPartyCertificate looks up the certificate registered for owner via
+// RegisterPartyCertificate.
+func (c *ChainOfCustodyContract) getPartyCertificate(ctx contractapi.TransactionContextInterface, owner string) (*x509.Certificate, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(partyCertIndex, []string{owner})
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
-	var evidences []*Evidence
+	certPEM, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if certPEM == nil {
+		return nil, fmt.Errorf("no enrolled certificate registered for %s", owner)
+	}
 
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
+	return parseCertificatePEM(string(certPEM))
+}
 
-		if strings.HasPrefix(queryResponse.Key, "TRANSFER_") || queryResponse.Key == "EVIDENCE_INDEX" {
-			continue
-		}
+func parseCertificatePEM(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, fmt.Errorf("not a valid PEM-encoded certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
 
-		var evidence Evidence
-		err = json.Unmarshal(queryResponse.Value, &evidence)
-		if err != nil {
-			continue
-		}
+// verifySignature checks that signatureB64 (base64-encoded) is a valid
+// signature over message made with the private key corresponding to cert.
+func (c *ChainOfCustodyContract) verifySignature(cert *x509.Certificate, message []byte, signatureB64 string) error {
+	if strings.TrimSpace(signatureB64) == "" {
+		return fmt.Errorf("signature must not be empty")
+	}
 
-		if evidence.ID == "" {
-			continue
+	signature, err := base64.StdEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return fmt.Errorf("signature is not valid base64: %v", err)
+	}
+
+	hashed := sha256.Sum256(message)
+
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, hashed[:], signature) {
+			return fmt.Errorf("ECDSA signature does not match certificate")
 		}
+		return nil
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], signature); err != nil {
+			return fmt.Errorf("RSA signature does not match certificate: %v", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported certificate public key type")
+	}
+}
 
-		evidences = append(evidences, &evidence)
+// RegisterEvidenceHash records the hex-encoded digest of the off-chain
+// evidence bytes so later reads can be checked for tampering with
+// VerifyEvidenceHash, or by comparing the PriorContentHash recorded on each
+// custody transfer (GetTransferHistoryForEvidence) against the ContentHash
+// values in GetEvidenceHistory.
+func (c *ChainOfCustodyContract) RegisterEvidenceHash(ctx contractapi.TransactionContextInterface, id string, algo string, hexDigest string) error {
+	if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
+		return err
 	}
 
-	return evidences, nil
-}
+	evidence, err := c.ReadEvidence(ctx, id)
+	if err != nil {
+		return err
+	}
 
-func (c *ChainOfCustodyContract) EvidenceExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
-	evidenceJSON, err := ctx.GetStub().GetState(id)
+	now, err := c.txTimestamp(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to read evidence: %v", err)
+		return err
 	}
 
-	return evidenceJSON != nil, nil
+	evidence.HashAlgo = algo
+	evidence.ContentHash = hexDigest
+	evidence.UpdatedAt = now
+
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(id, evidenceJSON)
 }
 
-func (c *ChainOfCustodyContract) addToEvidenceIndex(ctx contractapi.TransactionContextInterface, id string) error {
-	indexKey := "EVIDENCE_INDEX"
-	indexJSON, err := ctx.GetStub().GetState(indexKey)
-	
-	var index []string
+// VerifyEvidenceHash reports whether the currently registered content hash
+// for id matches algo/hexDigest, i.e. whether the off-chain bytes a verifier
+// is holding are the ones last registered on the ledger.
+func (c *ChainOfCustodyContract) VerifyEvidenceHash(ctx contractapi.TransactionContextInterface, id string, algo string, hexDigest string) (bool, error) {
+	evidence, err := c.ReadEvidence(ctx, id)
 	if err != nil {
+		return false, err
+	}
+
+	return strings.EqualFold(evidence.HashAlgo, algo) && strings.EqualFold(evidence.ContentHash, hexDigest), nil
+}
+
+func (c *ChainOfCustodyContract) LinkDerivedEvidence(ctx contractapi.TransactionContextInterface, parentID string, childID string, reason string) error {
+	if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
 		return err
 	}
-	if indexJSON != nil {
-		err = json.Unmarshal(indexJSON, &index)
-		if err != nil {
-			return err
-		}
+
+	if parentID == childID {
+		return fmt.Errorf("evidence %s cannot be derived from itself", parentID)
 	}
-	
-	for _, existingID := range index {
-		if existingID == id {
-			return nil 
-		}
+
+	parent, err := c.ReadEvidence(ctx, parentID)
+	if err != nil {
+		return err
 	}
-	
-	index = append(index, id)
-	updatedJSON, err := json.Marshal(index)
+
+	child, err := c.ReadEvidence(ctx, childID)
 	if err != nil {
 		return err
 	}
-	
-	return ctx.GetStub().PutState(indexKey, updatedJSON)
-}
 
-func (c *ChainOfCustodyContract) GetAllEvidenceIDs(ctx contractapi.TransactionContextInterface) ([]string, error) {
-	indexKey := "EVIDENCE_INDEX"
-	indexJSON, err := ctx.GetStub().GetState(indexKey)
+	now, err := c.txTimestamp(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if indexJSON == nil {
-		return []string{}, nil
+
+	link := DerivationLink{
+		ParentID:  parentID,
+		ChildID:   childID,
+		Reason:    reason,
+		Timestamp: now,
 	}
-	
-	var index []string
-	err = json.Unmarshal(indexJSON, &index)
+
+	linkJSON, err := json.Marshal(link)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	
-	return index, nil
-}
 
-func (c *ChainOfCustodyContract) DeleteEvidence(ctx contractapi.TransactionContextInterface, id string) error {
-	exists, err := c.EvidenceExists(ctx, id)
-	if err != nil {
+	linkKey := fmt.Sprintf("LINEAGE_%s_%s", parentID, childID)
+	if err := ctx.GetStub().PutState(linkKey, linkJSON); err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("evidence %s does not exist", id)
+
+	if !containsString(parent.DerivedIDs, childID) {
+		parent.DerivedIDs = append(parent.DerivedIDs, childID)
+		parent.UpdatedAt = link.Timestamp
+		parentJSON, err := json.Marshal(parent)
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(parentID, parentJSON); err != nil {
+			return err
+		}
+	}
+
+	if !containsString(child.ParentIDs, parentID) {
+		child.ParentIDs = append(child.ParentIDs, parentID)
+		child.UpdatedAt = link.Timestamp
+		childJSON, err := json.Marshal(child)
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(childID, childJSON); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *ChainOfCustodyContract) AttachCertificate(ctx contractapi.TransactionContextInterface, id string, certID string, certHash string, issuer string) error {
+	if err := c.authorize(ctx, "officer", "evidence-custodian"); err != nil {
+		return err
+	}
+
+	evidence, err := c.ReadEvidence(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	now, err := c.txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	cert := Certificate{
+		EvidenceID: id,
+		CertID:     certID,
+		CertHash:   certHash,
+		Issuer:     issuer,
+		Timestamp:  now,
+	}
+
+	certJSON, err := json.Marshal(cert)
+	if err != nil {
+		return err
+	}
+
+	certKey := fmt.Sprintf("CERT_%s_%s", id, certID)
+	if err := ctx.GetStub().PutState(certKey, certJSON); err != nil {
+		return err
+	}
+
+	if !containsString(evidence.Certificates, certHash) {
+		evidence.Certificates = append(evidence.Certificates, certHash)
+	}
+	evidence.UpdatedAt = cert.Timestamp
+
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetStub().PutState(id, evidenceJSON); err != nil {
+		return err
+	}
+
+	return c.emitEvidenceEvent(ctx, "CertificateAttached", id, map[string]interface{}{
+		"cert_id":   certID,
+		"cert_hash": certHash,
+		"issuer":    issuer,
+	})
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTransferHistoryForEvidence returns every custody transfer (including
+// soft-delete tombstones) recorded for id, in chronological order.
+func (c *ChainOfCustodyContract) GetTransferHistoryForEvidence(ctx contractapi.TransactionContextInterface, id string) ([]CustodyTransfer, error) {
+	return c.getTransfersForEvidence(ctx, id)
+}
+
+func (c *ChainOfCustodyContract) getTransfersForEvidence(ctx contractapi.TransactionContextInterface, id string) ([]CustodyTransfer, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(transferIndex, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var transfers []CustodyTransfer
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var transfer CustodyTransfer
+		if err := json.Unmarshal(queryResponse.Value, &transfer); err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, nil
+}
+
+func (c *ChainOfCustodyContract) getCertificatesForEvidence(ctx contractapi.TransactionContextInterface, id string) ([]Certificate, error) {
+	prefix := fmt.Sprintf("CERT_%s_", id)
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var certs []Certificate
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var cert Certificate
+		if err := json.Unmarshal(queryResponse.Value, &cert); err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+func (c *ChainOfCustodyContract) getDerivationLink(ctx contractapi.TransactionContextInterface, parentID string, childID string) (*DerivationLink, error) {
+	linkJSON, err := ctx.GetStub().GetState(fmt.Sprintf("LINEAGE_%s_%s", parentID, childID))
+	if err != nil {
+		return nil, err
+	}
+	if linkJSON == nil {
+		return nil, nil
+	}
+
+	var link DerivationLink
+	if err := json.Unmarshal(linkJSON, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// GetEvidenceLineage walks ParentIDs and DerivedIDs in both directions from
+// id, guarding against cycles with a visited set, and returns every evidence
+// record, derivation link, transfer and certificate found along the way so a
+// prosecutor can reconstruct how a piece of evidence was split, combined, or
+// analyzed.
+func (c *ChainOfCustodyContract) GetEvidenceLineage(ctx contractapi.TransactionContextInterface, id string) (*EvidenceLineage, error) {
+	lineage := &EvidenceLineage{
+		RootID:   id,
+		Evidence: make(map[string]*Evidence),
+	}
+
+	visited := make(map[string]bool)
+	seenLinks := make(map[string]bool)
+	queue := []string{id}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+
+		evidence, err := c.ReadEvidence(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		lineage.Evidence[current] = evidence
+
+		transfers, err := c.getTransfersForEvidence(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		lineage.Transfers = append(lineage.Transfers, transfers...)
+
+		certs, err := c.getCertificatesForEvidence(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		lineage.Certificates = append(lineage.Certificates, certs...)
+
+		for _, parentID := range evidence.ParentIDs {
+			linkKey := parentID + "->" + current
+			if !seenLinks[linkKey] {
+				seenLinks[linkKey] = true
+				if link, err := c.getDerivationLink(ctx, parentID, current); err != nil {
+					return nil, err
+				} else if link != nil {
+					lineage.Links = append(lineage.Links, *link)
+				}
+			}
+			if !visited[parentID] {
+				queue = append(queue, parentID)
+			}
+		}
+
+		for _, childID := range evidence.DerivedIDs {
+			linkKey := current + "->" + childID
+			if !seenLinks[linkKey] {
+				seenLinks[linkKey] = true
+				if link, err := c.getDerivationLink(ctx, current, childID); err != nil {
+					return nil, err
+				} else if link != nil {
+					lineage.Links = append(lineage.Links, *link)
+				}
+			}
+			if !visited[childID] {
+				queue = append(queue, childID)
+			}
+		}
+	}
+
+	return lineage, nil
+}
+
+func (c *ChainOfCustodyContract) GetEvidenceHistory(ctx contractapi.TransactionContextInterface, id string) ([]map[string]interface{}, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var history []map[string]interface{}
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var evidence Evidence
+		if len(response.Value) > 0 {
+			err = json.Unmarshal(response.Value, &evidence)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		record := map[string]interface{}{
+			"txId":      response.TxId,
+			"timestamp": response.Timestamp,
+			"isDelete":  response.IsDelete,
+			"evidence":  evidence,
+		}
+
+		history = append(history, record)
+	}
+
+	return history, nil
+}
+
+func (c *ChainOfCustodyContract) GetAllEvidence(ctx contractapi.TransactionContextInterface) ([]*Evidence, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return collectEvidence(resultsIterator)
+}
+
+// collectEvidence drains resultsIterator into a slice of Evidence, skipping
+// any key whose value is not a valid evidence record (composite-key index
+// entries, transfers, certificates, lineage links, and the POLICY key all
+// share the world state with evidence and fail one of these checks).
+func collectEvidence(resultsIterator shim.StateQueryIteratorInterface) ([]*Evidence, error) {
+	var evidences []*Evidence
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var evidence Evidence
+		if err := json.Unmarshal(queryResponse.Value, &evidence); err != nil {
+			continue
+		}
+
+		if evidence.ID == "" {
+			continue
+		}
+
+		evidences = append(evidences, &evidence)
+	}
+
+	return evidences, nil
+}
+
+// PaginatedEvidenceQueryResult is the shared response envelope for the
+// CouchDB-backed pagination APIs: the page of records actually fetched, the
+// opaque bookmark to pass back in to fetch the next page, and how many
+// records this page contains.
+type PaginatedEvidenceQueryResult struct {
+	Records             []*Evidence `json:"records"`
+	Bookmark            string      `json:"bookmark"`
+	FetchedRecordsCount int32       `json:"fetched_records_count"`
+}
+
+// GetAllEvidencePaginated is GetAllEvidence for CouchDB deployments: instead
+// of an unbounded range scan, it fetches at most pageSize records starting
+// from bookmark (an empty bookmark starts at the beginning) and returns the
+// bookmark to resume from for the next page.
+func (c *ChainOfCustodyContract) GetAllEvidencePaginated(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedEvidenceQueryResult, error) {
+	return paginateEvidence(bookmark, func(bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+		return ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	})
+}
+
+// QueryEvidence runs selectorJSON, a CouchDB Mango query document (e.g.
+// `{"selector":{"status":"In Transit","tags":{"$elemMatch":{"$eq":"firearm"}}}}`),
+// against the state database and returns a page of at most pageSize matching
+// evidence records starting from bookmark. It requires a CouchDB state
+// database; on LevelDB deployments the peer will reject the query.
+func (c *ChainOfCustodyContract) QueryEvidence(ctx contractapi.TransactionContextInterface, selectorJSON string, pageSize int32, bookmark string) (*PaginatedEvidenceQueryResult, error) {
+	return paginateEvidence(bookmark, func(bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+		return ctx.GetStub().GetQueryResultWithPagination(selectorJSON, pageSize, bookmark)
+	})
+}
+
+// paginateEvidence drives fetchPage (a GetStateByRangeWithPagination or
+// GetQueryResultWithPagination call bound to a fixed pageSize) and applies
+// collectEvidence to each page. Fabric's FetchedRecordsCount/Bookmark
+// describe raw keys scanned, not evidence records returned, so a page can
+// come back with a nonzero count and zero Records when it lands entirely on
+// non-evidence keys (composite indexes, TRANSFER~, CERT_, LINEAGE_,
+// PARTY_CERT~, POLICY). To keep that ambiguity out of the API, it re-fetches
+// with the advancing bookmark until a page actually yields a record or the
+// bookmark stops moving, which is the real end of the result set.
+func paginateEvidence(bookmark string, fetchPage func(bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error)) (*PaginatedEvidenceQueryResult, error) {
+	for {
+		resultsIterator, responseMetadata, err := fetchPage(bookmark)
+		if err != nil {
+			return nil, err
+		}
+
+		evidences, err := collectEvidence(resultsIterator)
+		closeErr := resultsIterator.Close()
+		if err != nil {
+			return nil, err
+		}
+		if closeErr != nil {
+			return nil, closeErr
+		}
+
+		result := &PaginatedEvidenceQueryResult{
+			Records:             evidences,
+			Bookmark:            responseMetadata.Bookmark,
+			FetchedRecordsCount: int32(len(evidences)),
+		}
+
+		if len(evidences) > 0 || responseMetadata.FetchedRecordsCount == 0 || responseMetadata.Bookmark == bookmark {
+			return result, nil
+		}
+
+		bookmark = responseMetadata.Bookmark
+	}
+}
+
+func (c *ChainOfCustodyContract) EvidenceExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	evidenceJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return false, fmt.Errorf("failed to read evidence: %v", err)
+	}
+
+	return evidenceJSON != nil, nil
+}
+
+// txTimestamp returns the transaction's peer-agreed timestamp formatted as
+// RFC3339. It must be used instead of time.Now() anywhere a value is written
+// to the ledger: time.Now() is evaluated independently by every endorsing
+// peer and would make their read/write sets diverge, causing spurious
+// MVCC_READ_CONFLICT failures at commit time.
+func (c *ChainOfCustodyContract) txTimestamp(ctx contractapi.TransactionContextInterface) (string, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve transaction timestamp: %v", err)
+	}
+	return ts.AsTime().UTC().Format(time.RFC3339), nil
+}
+
+// evidenceEvent is the JSON payload emitted with every chaincode event so
+// external SIEM/event-hub subscribers can reconstruct what changed, when,
+// and by whom without reading the ledger back.
+type evidenceEvent struct {
+	EvidenceID string                 `json:"evidence_id"`
+	TxID       string                 `json:"tx_id"`
+	Timestamp  string                 `json:"timestamp"`
+	Actor      string                 `json:"actor"`
+	Changes    map[string]interface{} `json:"changes,omitempty"`
+}
+
+// emitEvidenceEvent sets a chaincode event named eventName carrying an
+// evidenceEvent payload for evidenceID, stamped with the tx ID, the
+// deterministic tx timestamp, and the invoking client's identity.
+func (c *ChainOfCustodyContract) emitEvidenceEvent(ctx contractapi.TransactionContextInterface, eventName string, evidenceID string, changes map[string]interface{}) error {
+	actor, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to resolve invoking client identity: %v", err)
+	}
+
+	timestamp, err := c.txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(evidenceEvent{
+		EvidenceID: evidenceID,
+		TxID:       ctx.GetStub().GetTxID(),
+		Timestamp:  timestamp,
+		Actor:      actor,
+		Changes:    changes,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(eventName, payload)
+}
+
+func (c *ChainOfCustodyContract) getOrgPolicy(ctx contractapi.TransactionContextInterface) (OrgPolicy, error) {
+	policyJSON, err := ctx.GetStub().GetState(policyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := OrgPolicy{}
+	if policyJSON != nil {
+		if err := json.Unmarshal(policyJSON, &policy); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+func (c *ChainOfCustodyContract) putOrgPolicy(ctx contractapi.TransactionContextInterface, policy OrgPolicy) error {
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(policyKey, policyJSON)
+}
+
+// SetOrgPolicy grants mspID the given roles. The very first call bootstraps
+// the policy and is unguarded (there is no admin yet to guard it); every
+// call after that must come from an identity already holding the admin role
+// under the existing policy.
+func (c *ChainOfCustodyContract) SetOrgPolicy(ctx contractapi.TransactionContextInterface, mspID string, roles []string) error {
+	policy, err := c.getOrgPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(policy) > 0 {
+		if err := c.authorize(ctx, "admin"); err != nil {
+			return err
+		}
+	}
+
+	policy[mspID] = roles
+	return c.putOrgPolicy(ctx, policy)
+}
+
+// authorize rejects the invoking client unless its MSP ID is allow-listed
+// under the org policy for one of allowedRoles and its certificate carries a
+// matching "role" attribute, or it holds the admin role outright.
+func (c *ChainOfCustodyContract) authorize(ctx contractapi.TransactionContextInterface, allowedRoles ...string) error {
+	clientIdentity := ctx.GetClientIdentity()
+
+	mspID, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to resolve client MSP: %v", err)
+	}
+
+	policy, err := c.getOrgPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
+	orgRoles, ok := policy[mspID]
+	if !ok {
+		return fmt.Errorf("MSP %s is not authorized by org policy", mspID)
+	}
+
+	roleAttr, found, err := clientIdentity.GetAttributeValue("role")
+	if err != nil {
+		return fmt.Errorf("failed to read client role attribute: %v", err)
+	}
+	if !found {
+		return fmt.Errorf("client certificate does not carry a role attribute")
+	}
+
+	if !containsString(orgRoles, roleAttr) {
+		return fmt.Errorf("MSP %s is not authorized for role %s", mspID, roleAttr)
+	}
+
+	if roleAttr == "admin" {
+		return nil
+	}
+	for _, allowed := range allowedRoles {
+		if roleAttr == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("role %s is not permitted to perform this action", roleAttr)
+}
+
+// setEvidenceEndorsementPolicy attaches a key-level endorsement policy to id
+// so that only ownerMSPID (the org of the current owner) and auditorMSPID
+// must endorse future writes to that record.
+func (c *ChainOfCustodyContract) setEvidenceEndorsementPolicy(ctx contractapi.TransactionContextInterface, id string, ownerMSPID string) error {
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	if err := endorsementPolicy.AddOrgs(statebased.RoleTypePeer, ownerMSPID, auditorMSPID); err != nil {
+		return err
+	}
+	policyBytes, err := endorsementPolicy.Policy()
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().SetStateValidationParameter(id, policyBytes)
+}
+
+const (
+	ownerIndex    = "owner~id"
+	statusIndex   = "status~id"
+	tagIndex      = "tag~id"
+	claimTagIndex = "claim_tag~id"
+
+	// transferIndex namespaces custody transfer records under a composite
+	// key of evidenceID~timestamp so they iterate in chronological order per
+	// evidence ID, rather than colliding with real evidence IDs or being
+	// matched by substring filters over the world state.
+	transferIndex = "TRANSFER~evidenceID~timestamp"
+)
+
+// addEvidenceIndexes writes the owner~id, status~id, tag~id and claim_tag~id
+// composite keys for evidence so it can be looked up with
+// GetStateByPartialCompositeKey instead of scanning the whole world state.
+func (c *ChainOfCustodyContract) addEvidenceIndexes(ctx contractapi.TransactionContextInterface, evidence *Evidence) error {
+	if err := c.putEvidenceIndexEntry(ctx, ownerIndex, evidence.Owner, evidence.ID); err != nil {
+		return err
+	}
+	if err := c.putEvidenceIndexEntry(ctx, statusIndex, evidence.Status, evidence.ID); err != nil {
+		return err
+	}
+	for _, tag := range evidence.Tags {
+		if err := c.putEvidenceIndexEntry(ctx, tagIndex, tag, evidence.ID); err != nil {
+			return err
+		}
+	}
+	for _, claimTag := range evidence.ClaimTags {
+		if err := c.putEvidenceIndexEntry(ctx, claimTagIndex, claimTag, evidence.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEvidenceIndexes deletes the composite key index entries that were
+// written for evidence's current owner/status/tags/claim tags. Callers must
+// invoke this against the pre-mutation evidence record before writing any
+// changed owner/status/tags/claim tags, then call addEvidenceIndexes with
+// the updated record.
+func (c *ChainOfCustodyContract) removeEvidenceIndexes(ctx contractapi.TransactionContextInterface, evidence *Evidence) error {
+	if err := c.deleteEvidenceIndexEntry(ctx, ownerIndex, evidence.Owner, evidence.ID); err != nil {
+		return err
+	}
+	if err := c.deleteEvidenceIndexEntry(ctx, statusIndex, evidence.Status, evidence.ID); err != nil {
+		return err
+	}
+	for _, tag := range evidence.Tags {
+		if err := c.deleteEvidenceIndexEntry(ctx, tagIndex, tag, evidence.ID); err != nil {
+			return err
+		}
+	}
+	for _, claimTag := range evidence.ClaimTags {
+		if err := c.deleteEvidenceIndexEntry(ctx, claimTagIndex, claimTag, evidence.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ChainOfCustodyContract) putEvidenceIndexEntry(ctx contractapi.TransactionContextInterface, indexName string, attr string, id string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(indexName, []string{attr, id})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+func (c *ChainOfCustodyContract) deleteEvidenceIndexEntry(ctx contractapi.TransactionContextInterface, indexName string, attr string, id string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(indexName, []string{attr, id})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// queryEvidenceByIndex resolves every evidence record whose composite key
+// index entry matches attr, splitting the returned keys back into their
+// id attribute and reading the backing evidence record.
+func (c *ChainOfCustodyContract) queryEvidenceByIndex(ctx contractapi.TransactionContextInterface, indexName string, attr string) ([]*Evidence, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(indexName, []string{attr})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var evidences []*Evidence
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) != 2 {
+			continue
+		}
+
+		evidence, err := c.ReadEvidence(ctx, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		evidences = append(evidences, evidence)
+	}
+
+	return evidences, nil
+}
+
+func (c *ChainOfCustodyContract) GetEvidenceByOwner(ctx contractapi.TransactionContextInterface, owner string) ([]*Evidence, error) {
+	return c.queryEvidenceByIndex(ctx, ownerIndex, owner)
+}
+
+func (c *ChainOfCustodyContract) GetEvidenceByStatus(ctx contractapi.TransactionContextInterface, status string) ([]*Evidence, error) {
+	return c.queryEvidenceByIndex(ctx, statusIndex, status)
+}
+
+func (c *ChainOfCustodyContract) GetEvidenceByTag(ctx contractapi.TransactionContextInterface, tag string) ([]*Evidence, error) {
+	return c.queryEvidenceByIndex(ctx, tagIndex, tag)
+}
+
+// GetEvidenceByClaimTag resolves every evidence record carrying claimTag in
+// its ClaimTags, e.g. a prosecutor's or defense's case-theory label such as
+// "chain-of-custody-disputed" or "exhibit-A".
+func (c *ChainOfCustodyContract) GetEvidenceByClaimTag(ctx contractapi.TransactionContextInterface, claimTag string) ([]*Evidence, error) {
+	return c.queryEvidenceByIndex(ctx, claimTagIndex, claimTag)
+}
+
+// GetAllEvidenceIDs lists every evidence ID by walking the statusIndex
+// composite keys (every evidence record has exactly one status entry)
+// instead of ranging over and JSON-decoding the full world state, since
+// callers here only want the ID.
+func (c *ChainOfCustodyContract) GetAllEvidenceIDs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(statusIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var ids []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyParts) != 2 {
+			continue
+		}
+
+		ids = append(ids, keyParts[1])
+	}
+
+	return ids, nil
+}
+
+// DeleteEvidence does not remove the evidence record: destroying a record
+// outright would tear a hole in the custody audit trail. Instead it writes a
+// tombstone transfer and marks the record Status="Destroyed", so the record
+// and its full history remain readable via ReadEvidence/GetEvidenceHistory.
+func (c *ChainOfCustodyContract) DeleteEvidence(ctx contractapi.TransactionContextInterface, id string, reason string) error {
+	if err := c.authorize(ctx, "admin"); err != nil {
+		return err
+	}
+
+	evidence, err := c.ReadEvidence(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if evidence.Status == destroyedStatus {
+		return fmt.Errorf("evidence %s has already been destroyed", id)
+	}
+
+	deletedBy, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to resolve invoking client identity: %v", err)
+	}
+
+	now, err := c.txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	priorStatus := evidence.Status
+
+	tombstone := CustodyTransfer{
+		EvidenceID:       evidence.ID,
+		FromOwner:        evidence.Owner,
+		ToOwner:          evidence.Owner,
+		Timestamp:        now,
+		Reason:           fmt.Sprintf("destroyed: %s", reason),
+		TransferredBy:    deletedBy,
+		PriorContentHash: evidence.ContentHash,
+	}
+
+	tombstoneJSON, err := json.Marshal(tombstone)
+	if err != nil {
+		return err
+	}
+
+	tombstoneKey, err := ctx.GetStub().CreateCompositeKey(transferIndex, []string{evidence.ID, tombstone.Timestamp})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(tombstoneKey, tombstoneJSON); err != nil {
+		return err
+	}
+
+	if err := c.removeEvidenceIndexes(ctx, evidence); err != nil {
+		return err
+	}
+
+	evidence.Status = destroyedStatus
+	evidence.UpdatedAt = tombstone.Timestamp
+
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetStub().PutState(evidence.ID, evidenceJSON); err != nil {
+		return err
+	}
+
+	if err := c.addEvidenceIndexes(ctx, evidence); err != nil {
+		return err
 	}
 
-	return ctx.GetStub().DelState(id)
+	return c.emitEvidenceEvent(ctx, "EvidenceDeleted", evidence.ID, map[string]interface{}{
+		"status": map[string]string{"from": priorStatus, "to": destroyedStatus},
+		"reason": reason,
+	})
 }
 
 func main() {